@@ -0,0 +1,152 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// restoreAtomic implements Options.Atomic: it writes src to a "dst.new"
+// file, fsyncs it, and only then locks dst and renames dst.new into place.
+// The old dst is renamed to "dst.bak" rather than removed, so it survives
+// for manual recovery if anything goes wrong after the swap. If anything
+// fails before the rename, dst is never touched. If a "dst.bak" already
+// exists, the rename is refused rather than silently discarding it, since
+// it may be the one surviving copy from an earlier restore that failed or
+// was never cleaned up.
+func restoreAtomic(ctx context.Context, dst string, src io.Reader, opts Options) error {
+	dir := filepath.Dir(dst)
+	newPath := dst + ".new"
+	bakPath := dst + ".bak"
+
+	srcHeader, err := writeNewDatabase(newPath, src, opts)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("fsync parent directory before rename: %s", dir)
+
+	if err := fsyncDir(dir); err != nil {
+		return err
+	}
+
+	// Bind a single deadline for both the pre-rename lock below and the
+	// re-lock after the rename, so Options.Timeout bounds the whole locked
+	// section instead of restarting once per lock attempt.
+	lockCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		lockCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+		log.Printf("set lock timeout to: %s", opts.Timeout)
+	}
+
+	log.Printf("locking destination database: %s", dst)
+
+	h, err := LockDatabase(lockCtx, dst)
+	if err != nil {
+		_ = os.Remove(newPath)
+		return err
+	}
+
+	if _, err := os.Stat(bakPath); err == nil {
+		_ = h.Unlock()
+		return fmt.Errorf("back up destination database: %s already exists; move or remove it before retrying", bakPath)
+	} else if !os.IsNotExist(err) {
+		_ = h.Unlock()
+		return fmt.Errorf("back up destination database: %w", err)
+	}
+
+	log.Printf("backing up destination database: %s -> %s", dst, bakPath)
+
+	if err := os.Rename(dst, bakPath); err != nil {
+		_ = h.Unlock()
+		return fmt.Errorf("back up destination database: %w", err)
+	}
+
+	log.Printf("renaming new database into place: %s -> %s", newPath, dst)
+
+	if err := os.Rename(newPath, dst); err != nil {
+		_ = h.Unlock()
+		return fmt.Errorf("rename new database into place: %w", err)
+	}
+
+	// h's lock lives on the file descriptor for the old inode, which the
+	// rename above just repointed dst away from (to bakPath); it no longer
+	// protects the file that now occupies dst. Re-acquire the lock on that
+	// file before touching its WAL/SHM state any further, against the same
+	// deadline as the lock above.
+	if err := h.Unlock(); err != nil {
+		return fmt.Errorf("release pre-rename lock: %w", err)
+	}
+	log.Printf("locking renamed-in database: %s", dst)
+	h, err = LockDatabase(lockCtx, dst)
+	if err != nil {
+		return fmt.Errorf("lock renamed-in database: %w", err)
+	}
+	locked := true
+	defer func() {
+		if locked {
+			_ = h.Unlock()
+		}
+	}()
+
+	log.Printf("removing journal file: %s-journal", dst)
+
+	if err := os.Remove(dst + "-journal"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove journal file: %w", err)
+	}
+
+	if err := ensureWALState(h, dst, srcHeader, opts); err != nil {
+		return err
+	}
+
+	if h.shm != nil {
+		log.Printf("invalidating SHM file: %s", h.shm.Name())
+		if _, err := h.shm.WriteAt(make([]byte, 136), 0); err != nil {
+			return fmt.Errorf("invalidate shm file: %w", err)
+		}
+	}
+
+	log.Printf("fsync parent directory after rename: %s", dir)
+
+	if err := fsyncDir(dir); err != nil {
+		return err
+	}
+
+	return checkpointIfRequested(h, &locked, dst, srcHeader, opts)
+}
+
+// writeNewDatabase writes and verifies src into a fresh file at path,
+// fsyncing it before returning so it is durable ahead of the rename that
+// will put it into place. It returns the parsed source header so the
+// caller can make WAL/checkpoint decisions without re-parsing it.
+func writeNewDatabase(path string, src io.Reader, opts Options) (sqliteHeader, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return sqliteHeader{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	hdr, srcHeader, err := readAndVerifySource(src, opts)
+	if err != nil {
+		return sqliteHeader{}, err
+	}
+
+	log.Printf("writing new database: %s", path)
+
+	if _, err := io.Copy(f, io.MultiReader(bytes.NewReader(hdr), src)); err != nil {
+		return sqliteHeader{}, fmt.Errorf("write new database: %w", err)
+	}
+	if err := f.Truncate(opts.Size); err != nil {
+		return sqliteHeader{}, fmt.Errorf("set new database size: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return sqliteHeader{}, fmt.Errorf("sync new database: %w", err)
+	}
+	return srcHeader, nil
+}
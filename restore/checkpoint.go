@@ -0,0 +1,31 @@
+package restore
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// checkpointWAL runs PRAGMA wal_checkpoint(TRUNCATE) against dbPath,
+// folding its WAL frames back into the main database file and truncating
+// the WAL to zero length.
+//
+// Callers must not hold Restore's locks on dbPath when calling this: on
+// Windows, LockFileEx locks conflict across handles within the same
+// process (unlike POSIX fcntl, which coalesces locks held by the same
+// process), so the fresh database/sql connection opened here would
+// contend with them until its busy_timeout runs out and then fail. The
+// busy_timeout also covers another process being briefly in the way.
+func checkpointWAL(dbPath string) error {
+	db, err := sql.Open("sqlite", "file:"+dbPath+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return fmt.Errorf("open database for checkpoint: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("checkpoint database: %w", err)
+	}
+	return nil
+}
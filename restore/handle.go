@@ -0,0 +1,54 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// Handle owns the file descriptors backing an exclusive lock on an SQLite
+// database, acquired by LockDatabase. Callers should defer Unlock to
+// release them.
+type Handle struct {
+	db  *os.File
+	shm *os.File // non-nil only for a WAL-mode database
+}
+
+// LockDatabase opens dbPath and acquires the same locks that SQLite itself
+// takes for an exclusive connection: exclusive PENDING/RESERVED/SHARED locks
+// for a rollback-journal database, or a shared SHARED lock plus the SHM locks
+// for a WAL-mode database. This lets callers quiesce a database and compose
+// other operations, such as a snapshot upload, with the lock acquisition
+// that Restore performs internally.
+func LockDatabase(ctx context.Context, dbPath string) (*Handle, error) {
+	dbFile, err := os.OpenFile(dbPath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	var shmFile *os.File
+	if err := lockAll(ctx, dbFile, &shmFile); err != nil {
+		if shmFile != nil {
+			_ = shmFile.Close()
+		}
+		_ = dbFile.Close()
+		return nil, err
+	}
+
+	return &Handle{db: dbFile, shm: shmFile}, nil
+}
+
+// Unlock closes the handle's file descriptors, releasing the locks acquired
+// by LockDatabase.
+func (h *Handle) Unlock() error {
+	var errs []error
+	if h.shm != nil {
+		if err := h.shm.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := h.db.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
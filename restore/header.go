@@ -0,0 +1,120 @@
+package restore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// sqliteHeaderMagic is the fixed 16-byte string at the start of every
+// SQLite database file.
+const sqliteHeaderMagic = "SQLite format 3\x00"
+
+// sqliteHeader is the parsed form of the fields of the 100-byte SQLite
+// database header (https://www.sqlite.org/fileformat2.html#the_database_header)
+// that are needed to sanity-check a source database before it overwrites
+// a destination.
+type sqliteHeader struct {
+	PageSize      uint32
+	ReservedSpace byte
+	WriteFormat   byte
+	ReadFormat    byte
+	PageCount     uint32
+	FreelistTrunk uint32
+	FreelistCount uint32
+}
+
+// parseSQLiteHeader parses and validates the 100-byte SQLite database
+// header, returning an error if the magic string, page size, or reserved
+// space byte are malformed.
+func parseSQLiteHeader(hdr []byte) (sqliteHeader, error) {
+	if len(hdr) < 100 {
+		return sqliteHeader{}, fmt.Errorf("header too short: %d bytes", len(hdr))
+	}
+	if string(hdr[:16]) != sqliteHeaderMagic {
+		return sqliteHeader{}, fmt.Errorf("bad magic header, not an SQLite database")
+	}
+
+	pageSize := uint32(binary.BigEndian.Uint16(hdr[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536 // 1 is a special case meaning 65536.
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return sqliteHeader{}, fmt.Errorf("invalid page size: %d", pageSize)
+	}
+
+	reservedSpace := hdr[20]
+	if int(pageSize)-int(reservedSpace) < 480 {
+		return sqliteHeader{}, fmt.Errorf("invalid reserved space: %d", reservedSpace)
+	}
+
+	return sqliteHeader{
+		PageSize:      pageSize,
+		ReservedSpace: reservedSpace,
+		WriteFormat:   hdr[18],
+		ReadFormat:    hdr[19],
+		PageCount:     binary.BigEndian.Uint32(hdr[28:32]),
+		FreelistTrunk: binary.BigEndian.Uint32(hdr[32:36]),
+		FreelistCount: binary.BigEndian.Uint32(hdr[36:40]),
+	}, nil
+}
+
+// verifySourceHeader validates a freshly-read 100-byte SQLite header against
+// the total source size, returning the parsed header for further use (e.g.
+// an integrity check). A corrupt or truncated source — for example a
+// partial S3 download — would otherwise silently replace a live database
+// with garbage.
+func verifySourceHeader(hdr []byte, size int64) (sqliteHeader, error) {
+	h, err := parseSQLiteHeader(hdr)
+	if err != nil {
+		return sqliteHeader{}, fmt.Errorf("source database header: %w", err)
+	}
+	if h.WriteFormat != h.ReadFormat {
+		return sqliteHeader{}, fmt.Errorf("source database write format (%d) does not match read format (%d)", h.WriteFormat, h.ReadFormat)
+	}
+	if size%int64(h.PageSize) != 0 {
+		return sqliteHeader{}, fmt.Errorf("source database size (%d) is not a multiple of the page size (%d)", size, h.PageSize)
+	}
+	return h, nil
+}
+
+// checkSourceIntegrity walks the freelist recorded in the header and
+// confirms the declared page count and every freelist trunk page fit within
+// the source's actual size, and that the freelist length matches the
+// header's freelist count, catching sources that were truncated mid-write.
+// It requires random access to the source, so it is only run against
+// sources that support io.ReaderAt.
+func checkSourceIntegrity(f io.ReaderAt, h sqliteHeader, size int64) error {
+	pageCount := size / int64(h.PageSize)
+	if h.PageCount != 0 && int64(h.PageCount) > pageCount {
+		return fmt.Errorf("header reports %d pages but file only has %d", h.PageCount, pageCount)
+	}
+
+	trunk := h.FreelistTrunk
+	visited := make(map[uint32]bool)
+	var count uint32
+	for trunk != 0 {
+		if int64(trunk) > pageCount {
+			return fmt.Errorf("freelist trunk page %d is beyond end of file", trunk)
+		}
+		if visited[trunk] {
+			return fmt.Errorf("freelist trunk page %d visited twice (cycle)", trunk)
+		}
+		visited[trunk] = true
+
+		page := make([]byte, h.PageSize)
+		if _, err := f.ReadAt(page, (int64(trunk)-1)*int64(h.PageSize)); err != nil {
+			return fmt.Errorf("read freelist trunk page %d: %w", trunk, err)
+		}
+
+		next := binary.BigEndian.Uint32(page[0:4])
+		leafCount := binary.BigEndian.Uint32(page[4:8])
+		count += 1 + leafCount
+		trunk = next
+	}
+
+	if count != h.FreelistCount {
+		return fmt.Errorf("freelist has %d pages but header reports %d", count, h.FreelistCount)
+	}
+	return nil
+}
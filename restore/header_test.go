@@ -0,0 +1,202 @@
+package restore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// newTestHeader builds a 100-byte SQLite header with the magic string and
+// the given field values, so tests can set each field explicitly.
+func newTestHeader(t *testing.T, pageSize uint16, writeFormat, readFormat, reservedSpace byte, pageCount, freelistTrunk, freelistCount uint32) []byte {
+	t.Helper()
+
+	hdr := make([]byte, 100)
+	copy(hdr, sqliteHeaderMagic)
+	binary.BigEndian.PutUint16(hdr[16:18], pageSize)
+	hdr[18] = writeFormat
+	hdr[19] = readFormat
+	hdr[20] = reservedSpace
+	binary.BigEndian.PutUint32(hdr[28:32], pageCount)
+	binary.BigEndian.PutUint32(hdr[32:36], freelistTrunk)
+	binary.BigEndian.PutUint32(hdr[36:40], freelistCount)
+	return hdr
+}
+
+func TestParseSQLiteHeader(t *testing.T) {
+	t.Run("valid header", func(t *testing.T) {
+		hdr := newTestHeader(t, 4096, 1, 1, 0, 10, 0, 0)
+		h, err := parseSQLiteHeader(hdr)
+		if err != nil {
+			t.Fatalf("parseSQLiteHeader: %v", err)
+		}
+		if h.PageSize != 4096 {
+			t.Errorf("PageSize = %d, want 4096", h.PageSize)
+		}
+		if h.PageCount != 10 {
+			t.Errorf("PageCount = %d, want 10", h.PageCount)
+		}
+	})
+
+	t.Run("page size 1 means 65536", func(t *testing.T) {
+		hdr := newTestHeader(t, 1, 1, 1, 0, 1, 0, 0)
+		h, err := parseSQLiteHeader(hdr)
+		if err != nil {
+			t.Fatalf("parseSQLiteHeader: %v", err)
+		}
+		if h.PageSize != 65536 {
+			t.Errorf("PageSize = %d, want 65536", h.PageSize)
+		}
+	})
+
+	t.Run("too short", func(t *testing.T) {
+		if _, err := parseSQLiteHeader(make([]byte, 99)); err == nil {
+			t.Fatal("expected error for short header")
+		}
+	})
+
+	t.Run("bad magic", func(t *testing.T) {
+		hdr := newTestHeader(t, 4096, 1, 1, 0, 10, 0, 0)
+		hdr[0] = 'X'
+		if _, err := parseSQLiteHeader(hdr); err == nil {
+			t.Fatal("expected error for bad magic")
+		}
+	})
+
+	t.Run("non-power-of-two page size", func(t *testing.T) {
+		hdr := newTestHeader(t, 4097, 1, 1, 0, 10, 0, 0)
+		if _, err := parseSQLiteHeader(hdr); err == nil {
+			t.Fatal("expected error for non-power-of-two page size")
+		}
+	})
+
+	t.Run("page size too small", func(t *testing.T) {
+		hdr := newTestHeader(t, 256, 1, 1, 0, 10, 0, 0)
+		if _, err := parseSQLiteHeader(hdr); err == nil {
+			t.Fatal("expected error for page size below 512")
+		}
+	})
+
+	t.Run("invalid reserved space", func(t *testing.T) {
+		hdr := newTestHeader(t, 512, 1, 1, 100, 10, 0, 0)
+		if _, err := parseSQLiteHeader(hdr); err == nil {
+			t.Fatal("expected error for reserved space leaving too little usable page")
+		}
+	})
+}
+
+func TestVerifySourceHeader(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		hdr := newTestHeader(t, 4096, 1, 1, 0, 2, 0, 0)
+		if _, err := verifySourceHeader(hdr, 8192); err != nil {
+			t.Fatalf("verifySourceHeader: %v", err)
+		}
+	})
+
+	t.Run("write/read format mismatch", func(t *testing.T) {
+		hdr := newTestHeader(t, 4096, 1, 2, 0, 2, 0, 0)
+		if _, err := verifySourceHeader(hdr, 8192); err == nil {
+			t.Fatal("expected error for write/read format mismatch")
+		}
+	})
+
+	t.Run("size not a multiple of page size", func(t *testing.T) {
+		hdr := newTestHeader(t, 4096, 1, 1, 0, 2, 0, 0)
+		if _, err := verifySourceHeader(hdr, 8193); err == nil {
+			t.Fatal("expected error for size not a multiple of page size")
+		}
+	})
+}
+
+// fakeReaderAt serves ReadAt calls from a set of pages, 1-indexed to match
+// SQLite page numbers.
+type fakeReaderAt struct {
+	pageSize uint32
+	pages    map[uint32][]byte
+}
+
+func (f *fakeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	pageNum := uint32(off/int64(f.pageSize)) + 1
+	page, ok := f.pages[pageNum]
+	if !ok {
+		return 0, bytes.ErrTooLarge
+	}
+	return copy(p, page), nil
+}
+
+func freelistTrunkPage(pageSize uint32, next, leafCount uint32) []byte {
+	page := make([]byte, pageSize)
+	binary.BigEndian.PutUint32(page[0:4], next)
+	binary.BigEndian.PutUint32(page[4:8], leafCount)
+	return page
+}
+
+func TestCheckSourceIntegrity(t *testing.T) {
+	const pageSize = 512
+
+	t.Run("no freelist", func(t *testing.T) {
+		h := sqliteHeader{PageSize: pageSize, PageCount: 4, FreelistTrunk: 0, FreelistCount: 0}
+		f := &fakeReaderAt{pageSize: pageSize}
+		if err := checkSourceIntegrity(f, h, pageSize*4); err != nil {
+			t.Fatalf("checkSourceIntegrity: %v", err)
+		}
+	})
+
+	t.Run("single trunk page", func(t *testing.T) {
+		h := sqliteHeader{PageSize: pageSize, PageCount: 4, FreelistTrunk: 3, FreelistCount: 1}
+		f := &fakeReaderAt{pageSize: pageSize, pages: map[uint32][]byte{
+			3: freelistTrunkPage(pageSize, 0, 0),
+		}}
+		if err := checkSourceIntegrity(f, h, pageSize*4); err != nil {
+			t.Fatalf("checkSourceIntegrity: %v", err)
+		}
+	})
+
+	t.Run("chain of trunk pages", func(t *testing.T) {
+		h := sqliteHeader{PageSize: pageSize, PageCount: 5, FreelistTrunk: 4, FreelistCount: 4}
+		f := &fakeReaderAt{pageSize: pageSize, pages: map[uint32][]byte{
+			4: freelistTrunkPage(pageSize, 5, 1),
+			5: freelistTrunkPage(pageSize, 0, 1),
+		}}
+		if err := checkSourceIntegrity(f, h, pageSize*5); err != nil {
+			t.Fatalf("checkSourceIntegrity: %v", err)
+		}
+	})
+
+	t.Run("header overstates page count", func(t *testing.T) {
+		h := sqliteHeader{PageSize: pageSize, PageCount: 100, FreelistTrunk: 0, FreelistCount: 0}
+		f := &fakeReaderAt{pageSize: pageSize}
+		if err := checkSourceIntegrity(f, h, pageSize*4); err == nil {
+			t.Fatal("expected error when header page count exceeds file size")
+		}
+	})
+
+	t.Run("trunk page beyond end of file", func(t *testing.T) {
+		h := sqliteHeader{PageSize: pageSize, PageCount: 4, FreelistTrunk: 99, FreelistCount: 1}
+		f := &fakeReaderAt{pageSize: pageSize}
+		if err := checkSourceIntegrity(f, h, pageSize*4); err == nil {
+			t.Fatal("expected error for freelist trunk page beyond end of file")
+		}
+	})
+
+	t.Run("cyclic freelist", func(t *testing.T) {
+		h := sqliteHeader{PageSize: pageSize, PageCount: 5, FreelistTrunk: 4, FreelistCount: 99}
+		f := &fakeReaderAt{pageSize: pageSize, pages: map[uint32][]byte{
+			4: freelistTrunkPage(pageSize, 5, 0),
+			5: freelistTrunkPage(pageSize, 4, 0),
+		}}
+		if err := checkSourceIntegrity(f, h, pageSize*5); err == nil {
+			t.Fatal("expected error for cyclic freelist")
+		}
+	})
+
+	t.Run("freelist count mismatch", func(t *testing.T) {
+		h := sqliteHeader{PageSize: pageSize, PageCount: 4, FreelistTrunk: 3, FreelistCount: 5}
+		f := &fakeReaderAt{pageSize: pageSize, pages: map[uint32][]byte{
+			3: freelistTrunkPage(pageSize, 0, 0),
+		}}
+		if err := checkSourceIntegrity(f, h, pageSize*4); err == nil {
+			t.Fatal("expected error for freelist count mismatch")
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// Database file lock bytes.
+const (
+	PENDING  = 0x40000000
+	RESERVED = 0x40000001
+	SHARED   = 0x40000002
+)
+
+// SHM file lock bytes.
+const (
+	WRITE   = 120
+	CKPT    = 121
+	RECOVER = 122
+	READ0   = 123
+	READ1   = 124
+	READ2   = 125
+	READ3   = 126
+	READ4   = 127
+	DMS     = 128
+)
+
+// lockKind identifies the type of lock to acquire or release. It mirrors
+// the POSIX fcntl lock types but is declared locally so the platform-specific
+// lock implementations (lock_unix.go, lock_windows.go) don't need to share
+// an OS-specific package.
+type lockKind int16
+
+const (
+	lckUnlock lockKind = iota
+	lckRDLock
+	lckWRLock
+)
+
+// lockAll acquires the PENDING/RESERVED/SHARED (rollback-journal mode) or
+// SHM WRITE/CKPT/RECOVER/READ0-4/DMS (WAL mode) locks needed for exclusive
+// access to dbFile, the same locks SQLite itself takes for an exclusive
+// connection. For WAL-mode databases, *shmFile is set to the opened SHM
+// file; callers are responsible for closing it.
+func lockAll(ctx context.Context, dbFile *os.File, shmFile **os.File) error {
+	log.Printf("locking to determine journal mode")
+
+	// Acquire shared lock database file to determine mode.
+	if err := lock(ctx, dbFile, lckRDLock, PENDING); err != nil {
+		return fmt.Errorf("acquire PENDING lock: %w", err)
+	}
+	if err := lock(ctx, dbFile, lckRDLock, SHARED); err != nil {
+		return fmt.Errorf("acquire SHARED read lock: %w", err)
+	}
+	if err := lock(ctx, dbFile, lckUnlock, PENDING); err != nil {
+		return fmt.Errorf("release PENDING lock: %w", err)
+	}
+
+	log.Printf("reading database mode")
+
+	// Read mode from header.
+	isWAL, err := isWALMode(dbFile)
+	if err != nil {
+		return fmt.Errorf("read mode: %w", err)
+	}
+
+	// If journal mode, upgrade to write locks.
+	if !isWAL {
+		log.Printf("destination database is in journal mode")
+
+		if err := lock(ctx, dbFile, lckWRLock, RESERVED); err != nil {
+			return fmt.Errorf("acquire exclusive RESERVED lock: %w", err)
+		}
+		if err := lock(ctx, dbFile, lckWRLock, PENDING); err != nil {
+			return fmt.Errorf("acquire exclusive PENDING lock: %w", err)
+		}
+		// Release the shared SHARED lock before re-acquiring it exclusively:
+		// unlike POSIX fcntl, Windows' LockFileEx cannot upgrade a lock a
+		// process already holds on a byte range without unlocking it first.
+		if err := lock(ctx, dbFile, lckUnlock, SHARED); err != nil {
+			return fmt.Errorf("release SHARED read lock: %w", err)
+		}
+		if err := lock(ctx, dbFile, lckWRLock, SHARED); err != nil {
+			return fmt.Errorf("acquire exclusive SHARED lock: %w", err)
+		}
+		return nil
+	}
+
+	log.Printf("destination database is in WAL mode")
+
+	// If this is WAL mode then create the SHM file, if it doesn't exist.
+	*shmFile, err = os.OpenFile(dbFile.Name()+"-shm", os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+
+	// Then acquire all the SHM locks.
+	if err := lock(ctx, *shmFile, lckRDLock, DMS); err != nil {
+		return fmt.Errorf("acquire shared DMS lock: %w", err)
+	}
+	if err := lock(ctx, *shmFile, lckWRLock, WRITE); err != nil {
+		return fmt.Errorf("acquire exclusive WRITE lock: %w", err)
+	}
+	if err := lock(ctx, *shmFile, lckWRLock, CKPT); err != nil {
+		return fmt.Errorf("acquire exclusive CKPT lock: %w", err)
+	}
+	if err := lock(ctx, *shmFile, lckWRLock, RECOVER); err != nil {
+		return fmt.Errorf("acquire exclusive RECOVER lock: %w", err)
+	}
+	if err := lock(ctx, *shmFile, lckWRLock, READ0); err != nil {
+		return fmt.Errorf("acquire exclusive READ0 lock: %w", err)
+	}
+	if err := lock(ctx, *shmFile, lckWRLock, READ1); err != nil {
+		return fmt.Errorf("acquire exclusive READ1 lock: %w", err)
+	}
+	if err := lock(ctx, *shmFile, lckWRLock, READ2); err != nil {
+		return fmt.Errorf("acquire exclusive READ2 lock: %w", err)
+	}
+	if err := lock(ctx, *shmFile, lckWRLock, READ3); err != nil {
+		return fmt.Errorf("acquire exclusive READ3 lock: %w", err)
+	}
+	if err := lock(ctx, *shmFile, lckWRLock, READ4); err != nil {
+		return fmt.Errorf("acquire exclusive READ4 lock: %w", err)
+	}
+
+	return nil
+}
+
+func lockTypeName(typ lockKind) string {
+	switch typ {
+	case lckUnlock:
+		return "UNLCK"
+	case lckRDLock:
+		return "RDLCK"
+	case lckWRLock:
+		return "WRLCK"
+	default:
+		return fmt.Sprint(int16(typ))
+	}
+}
+
+// isWALMode returns true if the file format write version is 2 (WAL).
+func isWALMode(f *os.File) (bool, error) {
+	hdr := make([]byte, 100)
+	if _, err := io.ReadFull(f, hdr); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	} else if hdr[18] != hdr[19] {
+		return false, fmt.Errorf("database header write format (%d) does not match read format (%d)", hdr[18], hdr[19])
+	}
+	return hdr[18] == walWriteFormat, nil
+}
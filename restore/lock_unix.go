@@ -0,0 +1,63 @@
+//go:build !windows
+
+package restore
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+// lock acquires or releases a POSIX byte-range lock on f using fcntl(2),
+// polling until it succeeds or ctx is done.
+func lock(ctx context.Context, f *os.File, typ lockKind, byt int64) error {
+	start := byt
+	flockLen := int64(1)
+	if start == SHARED {
+		flockLen = 510
+	}
+
+	log.Printf("acquiring lock: (%s,%d,%d)", lockTypeName(typ), start, flockLen)
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		// Attempt non-blocking lock until we are successful.
+		flock := syscall.Flock_t{
+			Start:  start,
+			Len:    flockLen,
+			Type:   fcntlLockType(typ),
+			Whence: io.SeekStart,
+		}
+		if err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &flock); err == nil {
+			log.Printf("lock acquired")
+			return nil
+		}
+
+		// Report blocking PID.
+		log.Printf("lock failed, waiting on pid %d", flock.Pid)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// fcntlLockType maps a lockKind to the fcntl lock type constant it
+// corresponds to on POSIX systems.
+func fcntlLockType(typ lockKind) int16 {
+	switch typ {
+	case lckRDLock:
+		return syscall.F_RDLCK
+	case lckWRLock:
+		return syscall.F_WRLCK
+	default:
+		return syscall.F_UNLCK
+	}
+}
@@ -0,0 +1,290 @@
+// Package restore performs a locked, atomic swap of an SQLite database
+// file: it acquires the same locks an exclusive SQLite connection would
+// hold, copies a new database over the old one, and clears the old
+// database's journal/WAL state. It backs the sqlite3-restore CLI, but is
+// exposed as a library so other Go programs — backup daemons,
+// replicators, test harnesses — can perform the same swap in-process.
+package restore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Options configures a call to Restore.
+type Options struct {
+	// Size is the total size in bytes of src. Restore needs this up front
+	// to truncate dst and to validate the SQLite header's size invariants,
+	// since src is a plain io.Reader and may not be seekable.
+	Size int64
+
+	// CheckIntegrity walks src's freelist pages to verify internal
+	// consistency before committing the restore. It only runs if src
+	// implements io.ReaderAt.
+	CheckIntegrity bool
+
+	// Timeout bounds how long Restore waits to acquire dst's locks. Zero
+	// means wait forever.
+	Timeout time.Duration
+
+	// Atomic writes src to a "dst.new" file alongside dst and renames it
+	// into place once it is fully written and synced, instead of copying
+	// over dst in place. This means a process that dies mid-restore leaves
+	// dst untouched rather than corrupt, at the cost of holding dst's locks
+	// for only the rename and cleanup rather than the whole copy.
+	Atomic bool
+
+	// WAL, if set, is copied to dst's WAL file immediately after the main
+	// database, preserving frames from a WAL-mode source that haven't yet
+	// been checkpointed into it, so a consistent point-in-time snapshot of
+	// main database plus WAL frames can be restored rather than just the
+	// last checkpoint. WALSize must also be set. It is only honored when
+	// the source's header reports WAL mode; otherwise dst's existing WAL
+	// is simply truncated, as before. This is decided by the source, not
+	// by whether dst happened to already be in WAL mode: if dst wasn't, a
+	// WAL-mode source still needs dst's SHM file created so the restored
+	// WAL frames have somewhere to live.
+	WAL     io.Reader
+	WALSize int64
+
+	// Checkpoint runs PRAGMA wal_checkpoint(TRUNCATE) against dst after a
+	// WAL-mode restore, folding its WAL frames into the main database file.
+	// It runs after dst's locks are released, since the checkpoint opens
+	// its own connection to dst. That unlock-then-reconnect gap means a
+	// concurrent writer can slip in and hold dst's locks first; the
+	// checkpoint connection's 5s busy_timeout bounds how long it waits
+	// such a writer out, so Checkpoint can fail intermittently under the
+	// very concurrent load it's meant to handle, rather than block
+	// indefinitely.
+	Checkpoint bool
+}
+
+// Restore swaps dst for the database read from src: it acquires the locks
+// SQLite uses for an exclusive connection, copies src over dst, clears
+// dst's journal/WAL, and invalidates its SHM, all while those locks are
+// held. See Options.Atomic for a rename-based variant.
+func Restore(ctx context.Context, dst string, src io.Reader, opts Options) error {
+	if opts.Atomic {
+		return restoreAtomic(ctx, dst, src, opts)
+	}
+	return restoreInPlace(ctx, dst, src, opts)
+}
+
+func restoreInPlace(ctx context.Context, dst string, src io.Reader, opts Options) error {
+	h, err := lockDatabaseWithTimeout(ctx, dst, opts.Timeout)
+	if err != nil {
+		return err
+	}
+	locked := true
+	defer func() {
+		if locked {
+			_ = h.Unlock()
+		}
+	}()
+
+	log.Printf("removing journal file: %s-journal", dst)
+
+	// Remove the journal file if one exists.
+	if err := os.Remove(dst + "-journal"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove journal file: %w", err)
+	}
+
+	hdr, srcHeader, err := readAndVerifySource(src, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := ensureWALState(h, dst, srcHeader, opts); err != nil {
+		return err
+	}
+
+	if _, err := h.db.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	log.Printf("copying from source to destination: %s", dst)
+
+	if _, err := io.Copy(h.db, io.MultiReader(bytes.NewReader(hdr), src)); err != nil {
+		return fmt.Errorf("copy database: %w", err)
+	}
+
+	log.Printf("truncating destination database to size: %d", opts.Size)
+
+	if err := h.db.Truncate(opts.Size); err != nil {
+		return fmt.Errorf("set destination database size: %w", err)
+	}
+	if err := h.db.Sync(); err != nil {
+		return fmt.Errorf("sync database: %w", err)
+	}
+
+	// Invalidate SHM.
+	if h.shm != nil {
+		log.Printf("invalidating SHM file: %s", h.shm.Name())
+		if _, err := h.shm.WriteAt(make([]byte, 136), 0); err != nil {
+			return fmt.Errorf("invalidate shm file: %w", err)
+		}
+	}
+
+	log.Printf("fsync parent directory: %s", filepath.Dir(dst))
+
+	if err := fsyncDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+
+	return checkpointIfRequested(h, &locked, dst, srcHeader, opts)
+}
+
+// lockDatabaseWithTimeout calls LockDatabase, bounding the wait by timeout
+// if it is non-zero.
+func lockDatabaseWithTimeout(ctx context.Context, dst string, timeout time.Duration) (*Handle, error) {
+	lockCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		lockCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		log.Printf("set lock timeout to: %s", timeout)
+	}
+
+	log.Printf("locking destination database: %s", dst)
+
+	return LockDatabase(lockCtx, dst)
+}
+
+// readAndVerifySource reads src's 100-byte header and validates it against
+// opts, returning the header bytes (so the caller can prepend them back
+// onto src when copying) and the parsed header.
+func readAndVerifySource(src io.Reader, opts Options) ([]byte, sqliteHeader, error) {
+	log.Printf("verifying source database")
+
+	hdr := make([]byte, 100)
+	if _, err := io.ReadFull(src, hdr); err != nil {
+		return nil, sqliteHeader{}, fmt.Errorf("read source header: %w", err)
+	}
+	srcHeader, err := verifySourceHeader(hdr, opts.Size)
+	if err != nil {
+		return nil, sqliteHeader{}, err
+	}
+	if opts.CheckIntegrity {
+		if ra, ok := src.(io.ReaderAt); ok {
+			if err := checkSourceIntegrity(ra, srcHeader, opts.Size); err != nil {
+				return nil, sqliteHeader{}, fmt.Errorf("source database integrity check failed: %w", err)
+			}
+		} else {
+			log.Printf("skipping integrity check: source does not support random access")
+		}
+	}
+	return hdr, srcHeader, nil
+}
+
+// walWriteFormat is the SQLite header write-format byte value indicating a
+// WAL-mode database.
+const walWriteFormat = 2
+
+// ensureWALState reconciles dst's WAL and SHM files with srcHeader, the
+// header of the database that was just (or is about to be) restored over
+// dst. What decides whether dst needs a WAL file is whether the restore is
+// making it a WAL-mode database, not whether it already was one: in an
+// in-place restore h.shm reflects dst's state before the restore, from
+// lockAll inspecting dst's own pre-restore header, and a fresh or
+// previously-rollback-journal dst
+// being restored from a WAL-mode source would have a nil h.shm despite
+// needing a WAL file afterward.
+//
+// If srcHeader is WAL mode, it creates and tracks dst's SHM file on h if
+// lockAll didn't already open one, then copies over src's WAL frames, or
+// truncates a stale one if none were given. If srcHeader isn't WAL mode, it
+// unconditionally truncates any leftover dst-wal, so a WAL left over from
+// before the restore (or from a stale, never-cleaned-up prior attempt)
+// isn't replayed against the freshly-restored database by the next
+// WAL-aware connection.
+func ensureWALState(h *Handle, dst string, srcHeader sqliteHeader, opts Options) error {
+	if srcHeader.WriteFormat != walWriteFormat {
+		log.Printf("truncating WAL file: %s-wal", dst)
+		if err := os.Truncate(dst+"-wal", 0); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("truncate wal: %w", err)
+		}
+		return nil
+	}
+
+	if h.shm == nil {
+		log.Printf("creating SHM file: %s-shm", dst)
+		shm, err := os.OpenFile(dst+"-shm", os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			return fmt.Errorf("create shm file: %w", err)
+		}
+		h.shm = shm
+	}
+
+	if opts.WAL != nil {
+		log.Printf("copying WAL file: %s-wal", dst)
+		if err := writeCompanionFile(dst+"-wal", opts.WAL, opts.WALSize); err != nil {
+			return fmt.Errorf("copy wal file: %w", err)
+		}
+	} else {
+		log.Printf("truncating WAL file: %s-wal", dst)
+		if err := os.Truncate(dst+"-wal", 0); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("truncate wal: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkpointIfRequested runs a WAL checkpoint against dst if opts.Checkpoint
+// is set, srcHeader indicates a WAL-mode source, and h.shm is open (i.e.
+// ensureWALState actually set dst up as a WAL-mode database). It releases
+// h's lock first and updates
+// *locked to match. Checkpointing opens its own database/sql connection to
+// dst, and on Windows LockFileEx locks conflict across handles within the
+// same process (unlike POSIX fcntl, which coalesces locks held by the same
+// process), so that connection would contend with h's lock if it were
+// still held.
+func checkpointIfRequested(h *Handle, locked *bool, dst string, srcHeader sqliteHeader, opts Options) error {
+	if !opts.Checkpoint || srcHeader.WriteFormat != walWriteFormat || h.shm == nil {
+		return nil
+	}
+	*locked = false
+	if err := h.Unlock(); err != nil {
+		return fmt.Errorf("release lock before checkpoint: %w", err)
+	}
+	log.Printf("checkpointing WAL: %s", dst)
+	return checkpointWAL(dst)
+}
+
+// writeCompanionFile writes r to a fresh file at path, truncating it to
+// size and syncing it before returning.
+func writeCompanionFile(path string, r io.Reader, size int64) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := f.Truncate(size); err != nil {
+		return fmt.Errorf("set size of %s: %w", path, err)
+	}
+	return f.Sync()
+}
+
+// fsyncDir fsyncs dir itself, to ensure a file creation, rename, or removal
+// within it is durable.
+func fsyncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("directory sync: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testLockTimeout = 2 * time.Second
+
+// buildFakeDB returns pageCount pages of pageSize bytes each, with a valid
+// SQLite header (see header_test.go) stamped into the first page so it
+// passes verifySourceHeader. The page contents beyond the header are
+// distinguished by fill, so tests can tell which fake database ended up
+// where.
+func buildFakeDB(writeFormat byte, pageSize uint16, pageCount int, fill byte) []byte {
+	data := make([]byte, int(pageSize)*pageCount)
+	for i := range data {
+		data[i] = fill
+	}
+	copy(data, sqliteHeaderMagic)
+	binary.BigEndian.PutUint16(data[16:18], pageSize)
+	data[18] = writeFormat
+	data[19] = writeFormat
+	data[20] = 0
+	binary.BigEndian.PutUint32(data[28:32], uint32(pageCount))
+	binary.BigEndian.PutUint32(data[32:36], 0) // no freelist
+	binary.BigEndian.PutUint32(data[36:40], 0)
+	return data
+}
+
+func TestRestoreInPlace(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst.db")
+
+	oldData := buildFakeDB(1, 512, 2, 0xAA)
+	newData := buildFakeDB(1, 512, 2, 0xBB)
+
+	if err := os.WriteFile(dst, oldData, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst+"-journal", []byte("stale journal"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Size: int64(len(newData))}
+	if err := Restore(context.Background(), dst, bytes.NewReader(newData), opts); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, newData) {
+		t.Errorf("dst content not replaced with new database")
+	}
+	if _, err := os.Stat(dst + "-journal"); !os.IsNotExist(err) {
+		t.Errorf("journal file should have been removed, stat err = %v", err)
+	}
+}
+
+func TestRestoreAtomic(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst.db")
+
+	oldData := buildFakeDB(1, 512, 2, 0xAA)
+	newData := buildFakeDB(1, 512, 2, 0xBB)
+
+	if err := os.WriteFile(dst, oldData, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Size: int64(len(newData)), Atomic: true}
+	if err := Restore(context.Background(), dst, bytes.NewReader(newData), opts); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, newData) {
+		t.Errorf("dst does not contain the new database after the rename")
+	}
+
+	bak, err := os.ReadFile(dst + ".bak")
+	if err != nil {
+		t.Fatalf("read backup: %v", err)
+	}
+	if !bytes.Equal(bak, oldData) {
+		t.Errorf("dst.bak does not contain the pre-restore database")
+	}
+
+	// The restore must have released the lock it took on the file now at
+	// dst, so a fresh lock attempt should succeed immediately rather than
+	// timing out.
+	ctx, cancel := context.WithTimeout(context.Background(), testLockTimeout)
+	defer cancel()
+	h, err := LockDatabase(ctx, dst)
+	if err != nil {
+		t.Fatalf("LockDatabase after restore: %v", err)
+	}
+	if err := h.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRestoreAtomicRefusesToClobberExistingBackup guards against a second
+// atomic restore (or a retry after a prior failed one) silently destroying
+// a dst.bak left over from an earlier restore, which is the one copy of
+// the pre-restore database meant to survive for manual recovery.
+func TestRestoreAtomicRefusesToClobberExistingBackup(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst.db")
+
+	oldData := buildFakeDB(1, 512, 2, 0xAA)
+	newData := buildFakeDB(1, 512, 2, 0xBB)
+	existingBak := []byte("earlier backup, must survive")
+
+	if err := os.WriteFile(dst, oldData, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst+".bak", existingBak, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Size: int64(len(newData)), Atomic: true}
+	if err := Restore(context.Background(), dst, bytes.NewReader(newData), opts); err == nil {
+		t.Fatal("expected Restore to fail when dst.bak already exists")
+	}
+
+	bak, err := os.ReadFile(dst + ".bak")
+	if err != nil {
+		t.Fatalf("read backup: %v", err)
+	}
+	if !bytes.Equal(bak, existingBak) {
+		t.Errorf("existing dst.bak was overwritten")
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, oldData) {
+		t.Errorf("dst was modified despite the refused backup")
+	}
+}
+
+func TestRestoreAtomicWALCarryOver(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst.db")
+
+	// The source is in WAL mode, so ensureWALState takes the WAL-carry-over
+	// path instead of truncating, and the re-lock of the renamed-in database
+	// opens its SHM file so the SHM header gets invalidated.
+	oldData := buildFakeDB(2, 512, 2, 0xAA)
+	newData := buildFakeDB(2, 512, 2, 0xBB)
+	walData := []byte("fake wal frames")
+
+	if err := os.WriteFile(dst, oldData, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst+"-shm", make([]byte, 32768), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{
+		Size:    int64(len(newData)),
+		Atomic:  true,
+		WAL:     bytes.NewReader(walData),
+		WALSize: int64(len(walData)),
+	}
+	if err := Restore(context.Background(), dst, bytes.NewReader(newData), opts); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	gotWAL, err := os.ReadFile(dst + "-wal")
+	if err != nil {
+		t.Fatalf("read wal: %v", err)
+	}
+	if !bytes.Equal(gotWAL, walData) {
+		t.Errorf("dst-wal = %q, want %q", gotWAL, walData)
+	}
+
+	shm, err := os.ReadFile(dst + "-shm")
+	if err != nil {
+		t.Fatalf("read shm: %v", err)
+	}
+	if !bytes.Equal(shm[:136], make([]byte, 136)) {
+		t.Errorf("dst-shm header was not invalidated")
+	}
+}
+
+// TestRestoreWALOntoFreshDestination guards against restoring a WAL-mode
+// source onto a destination that doesn't exist yet (so lockAll's pre-restore
+// header check finds no WAL mode to carry over): the WAL frames must still
+// be written and dst's SHM file created, keyed off the source's write
+// format rather than dst's prior state.
+func TestRestoreWALOntoFreshDestination(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst.db")
+
+	newData := buildFakeDB(2, 512, 2, 0xBB)
+	walData := []byte("fake wal frames")
+
+	opts := Options{
+		Size:    int64(len(newData)),
+		WAL:     bytes.NewReader(walData),
+		WALSize: int64(len(walData)),
+	}
+	if err := Restore(context.Background(), dst, bytes.NewReader(newData), opts); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	gotWAL, err := os.ReadFile(dst + "-wal")
+	if err != nil {
+		t.Fatalf("read wal: %v", err)
+	}
+	if !bytes.Equal(gotWAL, walData) {
+		t.Errorf("dst-wal = %q, want %q", gotWAL, walData)
+	}
+	if _, err := os.Stat(dst + "-shm"); err != nil {
+		t.Errorf("dst-shm was not created: %v", err)
+	}
+}
+
+// TestRestoreClearsStaleWALOnNonWALDestination guards against a leftover
+// dst-wal from an earlier, unrelated WAL-mode database surviving a restore
+// from a non-WAL source just because dst itself wasn't in WAL mode
+// beforehand: it must be cleared unconditionally so a WAL-aware connection
+// doesn't later replay those stale frames against the freshly-restored
+// database.
+func TestRestoreClearsStaleWALOnNonWALDestination(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst.db")
+
+	oldData := buildFakeDB(1, 512, 2, 0xAA)
+	newData := buildFakeDB(1, 512, 2, 0xBB)
+
+	if err := os.WriteFile(dst, oldData, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst+"-wal", []byte("stale unrelated wal frames"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Size: int64(len(newData))}
+	if err := Restore(context.Background(), dst, bytes.NewReader(newData), opts); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	gotWAL, err := os.ReadFile(dst + "-wal")
+	if err != nil {
+		t.Fatalf("read wal: %v", err)
+	}
+	if len(gotWAL) != 0 {
+		t.Errorf("dst-wal = %q, want empty", gotWAL)
+	}
+}
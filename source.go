@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// source abstracts over the different places a restore can read its source
+// database bytes from, so run() doesn't need to know whether SRC is a local
+// file, stdin, or a remote URL.
+type source interface {
+	// Open returns a reader positioned at the start of the database along
+	// with its total size in bytes.
+	Open(ctx context.Context) (io.ReadCloser, int64, error)
+}
+
+// newSource resolves src to the appropriate source implementation: "-" for
+// stdin, "s3://bucket/key" for S3, "http(s)://" for a plain HTTP(S) fetch,
+// and anything else as a local file path.
+func newSource(src string) (source, error) {
+	switch {
+	case src == "-":
+		return &stdinSource{}, nil
+	case strings.HasPrefix(src, "s3://"):
+		return newS3Source(src)
+	case strings.HasPrefix(src, "http://"), strings.HasPrefix(src, "https://"):
+		return &httpSource{url: src}, nil
+	default:
+		return &fileSource{path: src}, nil
+	}
+}
+
+// fileSource reads a source database directly from the local filesystem.
+type fileSource struct {
+	path string
+}
+
+func (s *fileSource) Open(ctx context.Context) (io.ReadCloser, int64, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// openIfExists opens path and returns its size, or (nil, 0, nil) if path
+// does not exist.
+func openIfExists(path string) (*os.File, int64, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// stdinSource buffers stdin to a temp file, since Restore needs the source's
+// size before the copy begins (to validate the header and then truncate the
+// destination to it) and stdin doesn't know its length up front.
+type stdinSource struct{}
+
+func (s *stdinSource) Open(ctx context.Context) (io.ReadCloser, int64, error) {
+	tmp, err := os.CreateTemp("", "sqlite3-restore-stdin-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	// Unlink immediately; the descriptor stays valid until tmp is closed, and
+	// this guarantees the buffered copy is cleaned up even on a crash.
+	if err := os.Remove(tmp.Name()); err != nil {
+		_ = tmp.Close()
+		return nil, 0, err
+	}
+
+	n, err := io.Copy(tmp, os.Stdin)
+	if err != nil {
+		_ = tmp.Close()
+		return nil, 0, fmt.Errorf("buffer stdin: %w", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		_ = tmp.Close()
+		return nil, 0, err
+	}
+
+	return tmp, n, nil
+}
+
+// httpSource streams a source database from an HTTP(S) URL, using the
+// Content-Length response header as its size.
+type httpSource struct {
+	url string
+}
+
+func (s *httpSource) Open(ctx context.Context) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, 0, fmt.Errorf("fetch %s: unexpected status %s", s.url, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		_ = resp.Body.Close()
+		return nil, 0, fmt.Errorf("fetch %s: server did not return a Content-Length", s.url)
+	}
+
+	return resp.Body, resp.ContentLength, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3Source reads a source database directly from an S3 object, using
+// HeadObject to determine its size before streaming the download.
+type s3Source struct {
+	bucket string
+	key    string
+}
+
+// newS3Source parses an "s3://bucket/key" URI into an s3Source.
+func newS3Source(uri string) (*s3Source, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parse s3 url: %w", err)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("invalid s3 url, expected s3://bucket/key: %s", uri)
+	}
+	return &s3Source{bucket: u.Host, key: key}, nil
+}
+
+func (s *s3Source) Open(ctx context.Context) (io.ReadCloser, int64, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("load aws config: %w", err)
+	}
+	client := s3.NewFromConfig(cfg)
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &s.bucket, Key: &s.key})
+	if err != nil {
+		return nil, 0, fmt.Errorf("head s3://%s/%s: %w", s.bucket, s.key, err)
+	}
+
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &s.bucket, Key: &s.key})
+	if err != nil {
+		return nil, 0, fmt.Errorf("get s3://%s/%s: %w", s.bucket, s.key, err)
+	}
+
+	return out.Body, aws.ToInt64(head.ContentLength), nil
+}